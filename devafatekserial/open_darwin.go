@@ -0,0 +1,503 @@
+//go:build darwin
+
+package devhsmtekserial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// IOSSIOSPEED is the Darwin ioctl used to set a baud rate that the classic
+// termios speed encoding cannot represent, e.g. non-standard rates used by
+// some USB-serial adapters. See IOKit's IOSerialKeys.h.
+const iossiospeed = 0x80045402
+
+// Returns a pointer to an instantiated termios struct, based on the given
+// OpenOptions. Unlike Linux, Darwin's termios takes the baud rate directly in
+// Ispeed/Ospeed, so BOTHER-style tricks aren't needed for the standard rates;
+// IOSSIOSPEED (applied separately in apply) covers the rest.
+func makeTermios(options OpenOptions) (*unix.Termios, error) {
+
+	// Sanity check inter-character timeout and minimum read size options.
+
+	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
+	vmin := options.MinimumReadSize
+
+	if vmin == 0 && vtime < 100 {
+		return nil, errors.New("invalid values for InterCharacterTimeout and MinimumReadSize")
+	}
+
+	if vtime > 25500 {
+		return nil, errors.New("invalid value for InterCharacterTimeout")
+	}
+
+	t := &unix.Termios{
+		Cflag:  unix.CLOCAL | unix.CREAD,
+		Ispeed: uint64(options.BaudRate),
+		Ospeed: uint64(options.BaudRate),
+	}
+
+	t.Cc[unix.VTIME] = uint8(vtime / 100)
+	t.Cc[unix.VMIN] = uint8(vmin)
+
+	switch options.StopBits {
+	case 1:
+	case 2:
+		t.Cflag |= unix.CSTOPB
+
+	default:
+		return nil, errors.New("invalid setting for StopBits")
+	}
+
+	switch options.ParityMode {
+	case PARITY_NONE:
+	case PARITY_ODD:
+		t.Cflag |= unix.PARENB
+		t.Cflag |= unix.PARODD
+
+	case PARITY_EVEN:
+		t.Cflag |= unix.PARENB
+
+	default:
+		return nil, errors.New("invalid setting for ParityMode")
+	}
+
+	switch options.DataBits {
+	case 5:
+		t.Cflag |= unix.CS5
+	case 6:
+		t.Cflag |= unix.CS6
+	case 7:
+		t.Cflag |= unix.CS7
+	case 8:
+		t.Cflag |= unix.CS8
+	default:
+		return nil, errors.New("invalid setting for DataBits")
+	}
+
+	if options.RTSCTSFlowControl {
+		t.Cflag |= unix.CRTSCTS
+	}
+
+	return t, nil
+}
+
+// optionsFromTermios reverses makeTermios, decoding a termios read back from
+// the kernel into the subset of OpenOptions it can represent. Fields termios
+// knows nothing about (PortName, the Rs485* settings) are carried over from
+// base unchanged.
+func optionsFromTermios(t *unix.Termios, base OpenOptions) OpenOptions {
+	options := base
+
+	options.BaudRate = uint(t.Ospeed)
+	options.RTSCTSFlowControl = t.Cflag&unix.CRTSCTS != 0
+
+	switch {
+	case t.Cflag&unix.CS8 == unix.CS8:
+		options.DataBits = 8
+	case t.Cflag&unix.CS7 == unix.CS7:
+		options.DataBits = 7
+	case t.Cflag&unix.CS6 == unix.CS6:
+		options.DataBits = 6
+	default:
+		options.DataBits = 5
+	}
+
+	if t.Cflag&unix.CSTOPB != 0 {
+		options.StopBits = 2
+	} else {
+		options.StopBits = 1
+	}
+
+	switch {
+	case t.Cflag&unix.PARENB == 0:
+		options.ParityMode = PARITY_NONE
+	case t.Cflag&unix.PARODD != 0:
+		options.ParityMode = PARITY_ODD
+	default:
+		options.ParityMode = PARITY_EVEN
+	}
+
+	options.MinimumReadSize = uint(t.Cc[unix.VMIN])
+	options.InterCharacterTimeout = uint(t.Cc[unix.VTIME]) * 100
+
+	return options
+}
+
+// setNonstandardSpeed applies a baud rate that the classic termios speed
+// encoding can't represent via the Darwin-specific IOSSIOSPEED ioctl, so
+// StandardBaudRates has a user-visible effect.
+func setNonstandardSpeed(fd uintptr, baudRate uint) error {
+	speed := uint64(baudRate)
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		uintptr(iossiospeed),
+		uintptr(unsafe.Pointer(&speed)))
+
+	if errno != 0 {
+		return os.NewSyscallError("IOSSIOSPEED", errno)
+	}
+
+	return nil
+}
+
+// darwinPort is the Darwin implementation of Port. It wraps the underlying
+// *os.File and the OpenOptions last applied to it, so that the SetXxx
+// methods can recompute a full termios from a single changed field.
+type darwinPort struct {
+	file *os.File
+
+	// mu guards options against concurrent reconfiguration (SetXxx) and
+	// inspection (GetTermios) calls.
+	mu      sync.Mutex
+	options OpenOptions
+
+	// ctxMu guards the lazily-initialized non-blocking fd and cancellation
+	// self-pipe used by ReadContext, plus the deadline set by
+	// SetReadDeadline. These are kept separate from file so that plain Read
+	// keeps going through the blocking, VMIN/VTIME-driven fd unchanged.
+	ctxMu    sync.Mutex
+	ctxFD    int
+	cancelR  int
+	cancelW  int
+	deadline time.Time
+}
+
+func (p *darwinPort) Read(b []byte) (int, error)  { return p.file.Read(b) }
+func (p *darwinPort) Write(b []byte) (int, error) { return p.file.Write(b) }
+
+func (p *darwinPort) Close() error {
+	p.ctxMu.Lock()
+	if p.ctxFD > 0 {
+		unix.Close(p.ctxFD)
+		p.ctxFD = 0
+	}
+	if p.cancelR > 0 {
+		unix.Close(p.cancelR)
+		unix.Close(p.cancelW)
+		p.cancelR, p.cancelW = 0, 0
+	}
+	p.ctxMu.Unlock()
+
+	return p.file.Close()
+}
+
+// apply recomputes a termios from options and pushes it down via TIOCSETA,
+// following up with IOSSIOSPEED for rates the termios encoding can't carry.
+func (p *darwinPort) apply(options OpenOptions) error {
+	t, optErr := makeTermios(options)
+	if optErr != nil {
+		return optErr
+	}
+
+	if setErr := unix.IoctlSetTermios(int(p.file.Fd()), unix.TIOCSETA, t); setErr != nil {
+		return os.NewSyscallError("TIOCSETA", setErr)
+	}
+
+	if !IsStandardBaudRate(options.BaudRate) {
+		if speedErr := setNonstandardSpeed(p.file.Fd(), options.BaudRate); speedErr != nil {
+			return speedErr
+		}
+	}
+
+	p.options = options
+	return nil
+}
+
+func (p *darwinPort) SetBaudRate(baudRate uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.BaudRate = baudRate
+	return p.apply(options)
+}
+
+func (p *darwinPort) SetParity(parityMode ParityMode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.ParityMode = parityMode
+	return p.apply(options)
+}
+
+func (p *darwinPort) SetStopBits(stopBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.StopBits = stopBits
+	return p.apply(options)
+}
+
+func (p *darwinPort) SetDataBits(dataBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	return p.apply(options)
+}
+
+func (p *darwinPort) SetTimeouts(interCharacterTimeout, minimumReadSize uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.InterCharacterTimeout = interCharacterTimeout
+	options.MinimumReadSize = minimumReadSize
+	return p.apply(options)
+}
+
+func (p *darwinPort) GetTermios() (OpenOptions, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, err := unix.IoctlGetTermios(int(p.file.Fd()), unix.TIOCGETA)
+	if err != nil {
+		return OpenOptions{}, os.NewSyscallError("TIOCGETA", err)
+	}
+
+	return optionsFromTermios(t, p.options), nil
+}
+
+// modemBits reads the current state of the modem-control lines via TIOCMGET.
+func (p *darwinPort) modemBits() (int32, error) {
+	var bits int32
+
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		p.file.Fd(),
+		uintptr(unix.TIOCMGET),
+		uintptr(unsafe.Pointer(&bits)))
+
+	if errno != 0 {
+		return 0, os.NewSyscallError("SYS_IOCTL (TIOCMGET)", errno)
+	}
+
+	if r != 0 {
+		return 0, errors.New("unknown error from SYS_IOCTL (TIOCMGET)")
+	}
+
+	return bits, nil
+}
+
+// setModemBit raises (TIOCMBIS) or lowers (TIOCMBIC) a single modem-control
+// line bit.
+func (p *darwinPort) setModemBit(on bool, bit int32) error {
+	req := uintptr(unix.TIOCMBIC)
+	if on {
+		req = uintptr(unix.TIOCMBIS)
+	}
+
+	arg := bit
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		p.file.Fd(),
+		req,
+		uintptr(unsafe.Pointer(&arg)))
+
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCMBIS/TIOCMBIC)", errno)
+	}
+
+	if r != 0 {
+		return errors.New("unknown error from SYS_IOCTL (TIOCMBIS/TIOCMBIC)")
+	}
+
+	return nil
+}
+
+func (p *darwinPort) getModemBit(bit int32) (bool, error) {
+	bits, err := p.modemBits()
+	if err != nil {
+		return false, err
+	}
+
+	return bits&bit != 0, nil
+}
+
+func (p *darwinPort) SetDTR(on bool) error { return p.setModemBit(on, unix.TIOCM_DTR) }
+func (p *darwinPort) SetRTS(on bool) error { return p.setModemBit(on, unix.TIOCM_RTS) }
+
+func (p *darwinPort) GetCTS() (bool, error) { return p.getModemBit(unix.TIOCM_CTS) }
+func (p *darwinPort) GetDSR() (bool, error) { return p.getModemBit(unix.TIOCM_DSR) }
+func (p *darwinPort) GetRI() (bool, error)  { return p.getModemBit(unix.TIOCM_RI) }
+func (p *darwinPort) GetCD() (bool, error)  { return p.getModemBit(unix.TIOCM_CD) }
+
+// SendBreak asserts a break condition via TIOCSBRK, holds it for duration,
+// then clears it via TIOCCBRK.
+func (p *darwinPort) SendBreak(duration time.Duration) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TIOCSBRK), 0); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCSBRK)", errno)
+	}
+
+	time.Sleep(duration)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TIOCCBRK), 0); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCCBRK)", errno)
+	}
+
+	return nil
+}
+
+func (p *darwinPort) GetRS485Config() (RS485Config, error) {
+	return RS485Config{}, errors.New("RS485 mode is not supported on darwin")
+}
+
+func (p *darwinPort) SetRS485Config(RS485Config) error {
+	return errors.New("RS485 mode is not supported on darwin")
+}
+
+// Drain blocks until the kernel has finished transmitting all written data,
+// via the Darwin-specific TIOCDRAIN ioctl.
+func (p *darwinPort) Drain() error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TIOCDRAIN), 0); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCDRAIN)", errno)
+	}
+	return nil
+}
+
+// ensureCtxFD lazily opens a second, non-blocking file description on the
+// same device and a self-pipe used to wake a blocked poll() on
+// cancellation, since Darwin has no eventfd. A second open (rather than
+// reusing p.file's fd) keeps plain Read on the original blocking fd
+// unaffected by VMIN/VTIME, since O_NONBLOCK is a property of the open file
+// description, not the device.
+func (p *darwinPort) ensureCtxFD() error {
+	p.ctxMu.Lock()
+	defer p.ctxMu.Unlock()
+
+	if p.ctxFD != 0 {
+		return nil
+	}
+
+	ctxFD, openErr := unix.Open(p.file.Name(), unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if openErr != nil {
+		return os.NewSyscallError("open", openErr)
+	}
+
+	var pipeFDs [2]int
+	if pipeErr := unix.Pipe(pipeFDs[:]); pipeErr != nil {
+		unix.Close(ctxFD)
+		return os.NewSyscallError("pipe", pipeErr)
+	}
+
+	p.ctxFD = ctxFD
+	p.cancelR, p.cancelW = pipeFDs[0], pipeFDs[1]
+	return nil
+}
+
+func (p *darwinPort) SetReadDeadline(t time.Time) error {
+	p.ctxMu.Lock()
+	p.deadline = t
+	p.ctxMu.Unlock()
+	return nil
+}
+
+// ReadContext reads from the non-blocking ctxFD, using poll() to wait for
+// readability, the configured deadline, or a wakeup on the cancellation
+// pipe (written to by the goroutine below when ctx is done).
+func (p *darwinPort) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+
+	if err := p.ensureCtxFD(); err != nil {
+		return 0, err
+	}
+
+	p.ctxMu.Lock()
+	ctxFD, cancelR, cancelW, deadline := p.ctxFD, p.cancelR, p.cancelW, p.deadline
+	p.ctxMu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			unix.Write(cancelW, []byte{0})
+		case <-done:
+		}
+	}()
+
+	timeoutMS := -1
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timeoutMS = int((remaining + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	for {
+		n, readErr := unix.Read(ctxFD, b)
+		if readErr == nil && n > 0 {
+			return n, nil
+		}
+		if readErr != nil && readErr != unix.EAGAIN {
+			return 0, os.NewSyscallError("read", readErr)
+		}
+
+		fds := []unix.PollFd{
+			{Fd: int32(ctxFD), Events: unix.POLLIN},
+			{Fd: int32(cancelR), Events: unix.POLLIN},
+		}
+
+		_, pollErr := unix.Poll(fds, timeoutMS)
+		if pollErr != nil && pollErr != unix.EINTR {
+			return 0, os.NewSyscallError("poll", pollErr)
+		}
+
+		if fds[1].Revents&unix.POLLIN != 0 {
+			var ack [1]byte
+			unix.Read(cancelR, ack[:])
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+		}
+
+		if pollErr == nil && fds[0].Revents&unix.POLLIN == 0 && fds[1].Revents&unix.POLLIN == 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func openInternal(options OpenOptions) (Port, error) {
+
+	file, openErr :=
+		os.OpenFile(
+			options.PortName,
+			syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
+			0600)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	// Clear the non-blocking flag set above.
+	nonblockErr := syscall.SetNonblock(int(file.Fd()), false)
+	if nonblockErr != nil {
+		return nil, nonblockErr
+	}
+
+	if effectiveRS485Config(options).Enabled {
+		return nil, errors.New("RS485 mode is not supported on darwin")
+	}
+
+	port := &darwinPort{file: file}
+	if applyErr := port.apply(options); applyErr != nil {
+		return nil, applyErr
+	}
+
+	return port, nil
+}
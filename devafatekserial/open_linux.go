@@ -0,0 +1,664 @@
+//go:build linux
+
+package devhsmtekserial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+//
+// Grab the constants with the following little program, to avoid using cgo:
+//
+// #include <stdio.h>
+// #include <stdlib.h>
+// #include <linux/termios.h>
+//
+// int main(int argc, const char **argv) {
+//   printf("TCGETS2 = 0x%08X\n", TCGETS2);
+//   printf("TCSETS2 = 0x%08X\n", TCSETS2);
+//   printf("BOTHER  = 0x%08X\n", BOTHER);
+//   printf("NCCS    = %d\n",     NCCS);
+//   return 0;
+// }
+//
+const (
+	kTCGETS2 = 0x802C542A
+	kTCSETS2 = 0x402C542B
+	kBOTHER  = 0x1000
+	kNCCS    = 19
+)
+
+//
+// Types from asm-generic/termbits.h
+//
+
+type cc_t byte
+type speed_t uint32
+type tcflag_t uint32
+type termios2 struct {
+	c_iflag  tcflag_t    // input mode flags
+	c_oflag  tcflag_t    // output mode flags
+	c_cflag  tcflag_t    // control mode flags
+	c_lflag  tcflag_t    // local mode flags
+	c_line   cc_t        // line discipline
+	c_cc     [kNCCS]cc_t // control characters
+	c_ispeed speed_t     // input speed
+	c_ospeed speed_t     // output speed
+}
+
+// Constants for RS485 operation
+
+const (
+	sER_RS485_ENABLED        = (1 << 0)
+	sER_RS485_RTS_ON_SEND    = (1 << 1)
+	sER_RS485_RTS_AFTER_SEND = (1 << 2)
+	sER_RS485_RX_DURING_TX   = (1 << 4)
+	tIOCGRS485               = 0x542E
+	tIOCSRS485               = 0x542F
+)
+
+type serial_rs485 struct {
+	flags                 uint32
+	delay_rts_before_send uint32
+	delay_rts_after_send  uint32
+	padding               [5]uint32
+}
+
+// rs485ToLinux translates an RS485Config into the serial_rs485 the
+// TIOCSRS485 ioctl expects.
+func rs485ToLinux(config RS485Config) serial_rs485 {
+	rs485 := serial_rs485{
+		delay_rts_before_send: uint32(config.DelayRTSBeforeSend),
+		delay_rts_after_send:  uint32(config.DelayRTSAfterSend),
+	}
+
+	if config.Enabled {
+		rs485.flags |= sER_RS485_ENABLED
+	}
+
+	if config.RTSOnSend {
+		rs485.flags |= sER_RS485_RTS_ON_SEND
+	}
+
+	if config.RTSAfterSend {
+		rs485.flags |= sER_RS485_RTS_AFTER_SEND
+	}
+
+	if config.RxDuringTx {
+		rs485.flags |= sER_RS485_RX_DURING_TX
+	}
+
+	return rs485
+}
+
+// rs485FromLinux reverses rs485ToLinux, decoding a serial_rs485 read back
+// from TIOCGRS485.
+func rs485FromLinux(rs485 serial_rs485) RS485Config {
+	return RS485Config{
+		Enabled:            rs485.flags&sER_RS485_ENABLED != 0,
+		RTSOnSend:          rs485.flags&sER_RS485_RTS_ON_SEND != 0,
+		RTSAfterSend:       rs485.flags&sER_RS485_RTS_AFTER_SEND != 0,
+		RxDuringTx:         rs485.flags&sER_RS485_RX_DURING_TX != 0,
+		DelayRTSBeforeSend: int(rs485.delay_rts_before_send),
+		DelayRTSAfterSend:  int(rs485.delay_rts_after_send),
+	}
+}
+
+// getRS485 reads the current RS485 settings of fd via TIOCGRS485.
+func getRS485(fd uintptr) (RS485Config, error) {
+	rs485 := serial_rs485{}
+
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		uintptr(tIOCGRS485),
+		uintptr(unsafe.Pointer(&rs485)))
+
+	if errno != 0 {
+		return RS485Config{}, os.NewSyscallError("SYS_IOCTL (TIOCGRS485)", errno)
+	}
+
+	if r != 0 {
+		return RS485Config{}, errors.New("unknown error from SYS_IOCTL (TIOCGRS485)")
+	}
+
+	return rs485FromLinux(rs485), nil
+}
+
+// setRS485 applies config to fd via TIOCSRS485.
+func setRS485(fd uintptr, config RS485Config) error {
+	rs485 := rs485ToLinux(config)
+
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		uintptr(tIOCSRS485),
+		uintptr(unsafe.Pointer(&rs485)))
+
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCSRS485)", errno)
+	}
+
+	if r != 0 {
+		return errors.New("unknown error from SYS_IOCTL (TIOCSRS485)")
+	}
+
+	return nil
+}
+
+//
+// Returns a pointer to an instantiates termios2 struct, based on the given
+// OpenOptions. Termios2 is a Linux extension which allows arbitrary baud rates
+// to be specified.
+//
+func makeTermios2(options OpenOptions) (*termios2, error) {
+
+	// Sanity check inter-character timeout and minimum read size options.
+
+	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
+	vmin := options.MinimumReadSize
+
+	if vmin == 0 && vtime < 100 {
+		return nil, errors.New("invalid values for InterCharacterTimeout and MinimumReadSize")
+	}
+
+	if vtime > 25500 {
+		return nil, errors.New("invalid value for InterCharacterTimeout")
+	}
+
+	ccOpts := [kNCCS]cc_t{}
+	ccOpts[syscall.VTIME] = cc_t(vtime / 100)
+	ccOpts[syscall.VMIN] = cc_t(vmin)
+
+	t2 := &termios2{
+		c_cflag:  syscall.CLOCAL | syscall.CREAD | kBOTHER,
+		c_ispeed: speed_t(options.BaudRate),
+		c_ospeed: speed_t(options.BaudRate),
+		c_cc:     ccOpts,
+	}
+
+	switch options.StopBits {
+	case 1:
+	case 2:
+		t2.c_cflag |= syscall.CSTOPB
+
+	default:
+		return nil, errors.New("invalid setting for StopBits")
+	}
+
+	switch options.ParityMode {
+	case PARITY_NONE:
+	case PARITY_ODD:
+		t2.c_cflag |= syscall.PARENB
+		t2.c_cflag |= syscall.PARODD
+
+	case PARITY_EVEN:
+		t2.c_cflag |= syscall.PARENB
+
+	default:
+		return nil, errors.New("invalid setting for ParityMode")
+	}
+
+	switch options.DataBits {
+	case 5:
+		t2.c_cflag |= syscall.CS5
+	case 6:
+		t2.c_cflag |= syscall.CS6
+	case 7:
+		t2.c_cflag |= syscall.CS7
+	case 8:
+		t2.c_cflag |= syscall.CS8
+	default:
+		return nil, errors.New("invalid setting for DataBits")
+	}
+
+	if options.RTSCTSFlowControl {
+		t2.c_cflag |= unix.CRTSCTS
+	}
+
+	return t2, nil
+}
+
+// optionsFromTermios2 reverses makeTermios2, decoding a termios2 read back
+// from the kernel into the subset of OpenOptions it can represent. Fields
+// termios2 knows nothing about (PortName, the Rs485* settings) are carried
+// over from base unchanged.
+func optionsFromTermios2(t2 *termios2, base OpenOptions) OpenOptions {
+	options := base
+
+	options.BaudRate = uint(t2.c_ospeed)
+	options.RTSCTSFlowControl = t2.c_cflag&unix.CRTSCTS != 0
+
+	switch {
+	case t2.c_cflag&syscall.CS8 == syscall.CS8:
+		options.DataBits = 8
+	case t2.c_cflag&syscall.CS7 == syscall.CS7:
+		options.DataBits = 7
+	case t2.c_cflag&syscall.CS6 == syscall.CS6:
+		options.DataBits = 6
+	default:
+		options.DataBits = 5
+	}
+
+	if t2.c_cflag&syscall.CSTOPB != 0 {
+		options.StopBits = 2
+	} else {
+		options.StopBits = 1
+	}
+
+	switch {
+	case t2.c_cflag&syscall.PARENB == 0:
+		options.ParityMode = PARITY_NONE
+	case t2.c_cflag&syscall.PARODD != 0:
+		options.ParityMode = PARITY_ODD
+	default:
+		options.ParityMode = PARITY_EVEN
+	}
+
+	options.MinimumReadSize = uint(t2.c_cc[syscall.VMIN])
+	options.InterCharacterTimeout = uint(t2.c_cc[syscall.VTIME]) * 100
+
+	return options
+}
+
+// getTermios2 reads the current termios2 settings of fd via TCGETS2.
+func getTermios2(fd uintptr) (*termios2, error) {
+	t2 := &termios2{}
+
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		uintptr(kTCGETS2),
+		uintptr(unsafe.Pointer(t2)))
+
+	if errno != 0 {
+		return nil, os.NewSyscallError("SYS_IOCTL (TCGETS2)", errno)
+	}
+
+	if r != 0 {
+		return nil, errors.New("unknown error from SYS_IOCTL (TCGETS2)")
+	}
+
+	return t2, nil
+}
+
+// setTermios2 applies t2 to fd via TCSETS2.
+func setTermios2(fd uintptr, t2 *termios2) error {
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		uintptr(kTCSETS2),
+		uintptr(unsafe.Pointer(t2)))
+
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TCSETS2)", errno)
+	}
+
+	if r != 0 {
+		return errors.New("unknown error from SYS_IOCTL (TCSETS2)")
+	}
+
+	return nil
+}
+
+// linuxPort is the Linux implementation of Port. It wraps the underlying
+// *os.File and the OpenOptions last applied to it, so that the SetXxx
+// methods can recompute a full termios2 from a single changed field.
+type linuxPort struct {
+	file *os.File
+
+	// mu guards options against concurrent reconfiguration (SetXxx) and
+	// inspection (GetTermios) calls.
+	mu      sync.Mutex
+	options OpenOptions
+
+	// ctxMu guards the lazily-initialized non-blocking fd and eventfd used
+	// by ReadContext, plus the deadline set by SetReadDeadline. These are
+	// kept separate from file so that plain Read keeps going through the
+	// blocking, VMIN/VTIME-driven fd unchanged.
+	ctxMu    sync.Mutex
+	ctxFD    int
+	cancelFD int
+	deadline time.Time
+}
+
+func (p *linuxPort) Read(b []byte) (int, error)  { return p.file.Read(b) }
+func (p *linuxPort) Write(b []byte) (int, error) { return p.file.Write(b) }
+
+func (p *linuxPort) Close() error {
+	p.ctxMu.Lock()
+	if p.ctxFD > 0 {
+		unix.Close(p.ctxFD)
+		p.ctxFD = 0
+	}
+	if p.cancelFD > 0 {
+		unix.Close(p.cancelFD)
+		p.cancelFD = 0
+	}
+	p.ctxMu.Unlock()
+
+	return p.file.Close()
+}
+
+// apply recomputes a termios2 from options, pushes it down via TCSETS2, and
+// records options in p.options once that succeeds.
+func (p *linuxPort) apply(options OpenOptions) error {
+	t2, optErr := makeTermios2(options)
+	if optErr != nil {
+		return optErr
+	}
+
+	if setErr := setTermios2(p.file.Fd(), t2); setErr != nil {
+		return setErr
+	}
+
+	p.options = options
+	return nil
+}
+
+func (p *linuxPort) SetBaudRate(baudRate uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.BaudRate = baudRate
+	return p.apply(options)
+}
+
+func (p *linuxPort) SetParity(parityMode ParityMode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.ParityMode = parityMode
+	return p.apply(options)
+}
+
+func (p *linuxPort) SetStopBits(stopBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.StopBits = stopBits
+	return p.apply(options)
+}
+
+func (p *linuxPort) SetDataBits(dataBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	return p.apply(options)
+}
+
+func (p *linuxPort) SetTimeouts(interCharacterTimeout, minimumReadSize uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.InterCharacterTimeout = interCharacterTimeout
+	options.MinimumReadSize = minimumReadSize
+	return p.apply(options)
+}
+
+func (p *linuxPort) GetTermios() (OpenOptions, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t2, err := getTermios2(p.file.Fd())
+	if err != nil {
+		return OpenOptions{}, err
+	}
+
+	return optionsFromTermios2(t2, p.options), nil
+}
+
+// modemBits reads the current state of the modem-control lines via TIOCMGET.
+func (p *linuxPort) modemBits() (int32, error) {
+	var bits int32
+
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		p.file.Fd(),
+		uintptr(unix.TIOCMGET),
+		uintptr(unsafe.Pointer(&bits)))
+
+	if errno != 0 {
+		return 0, os.NewSyscallError("SYS_IOCTL (TIOCMGET)", errno)
+	}
+
+	if r != 0 {
+		return 0, errors.New("unknown error from SYS_IOCTL (TIOCMGET)")
+	}
+
+	return bits, nil
+}
+
+// setModemBit raises (TIOCMBIS) or lowers (TIOCMBIC) a single modem-control
+// line bit.
+func (p *linuxPort) setModemBit(on bool, bit int32) error {
+	req := uintptr(unix.TIOCMBIC)
+	if on {
+		req = uintptr(unix.TIOCMBIS)
+	}
+
+	arg := bit
+	r, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		p.file.Fd(),
+		req,
+		uintptr(unsafe.Pointer(&arg)))
+
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCMBIS/TIOCMBIC)", errno)
+	}
+
+	if r != 0 {
+		return errors.New("unknown error from SYS_IOCTL (TIOCMBIS/TIOCMBIC)")
+	}
+
+	return nil
+}
+
+func (p *linuxPort) getModemBit(bit int32) (bool, error) {
+	bits, err := p.modemBits()
+	if err != nil {
+		return false, err
+	}
+
+	return bits&bit != 0, nil
+}
+
+func (p *linuxPort) SetDTR(on bool) error { return p.setModemBit(on, unix.TIOCM_DTR) }
+func (p *linuxPort) SetRTS(on bool) error { return p.setModemBit(on, unix.TIOCM_RTS) }
+
+func (p *linuxPort) GetCTS() (bool, error) { return p.getModemBit(unix.TIOCM_CTS) }
+func (p *linuxPort) GetDSR() (bool, error) { return p.getModemBit(unix.TIOCM_DSR) }
+func (p *linuxPort) GetRI() (bool, error)  { return p.getModemBit(unix.TIOCM_RI) }
+func (p *linuxPort) GetCD() (bool, error)  { return p.getModemBit(unix.TIOCM_CD) }
+
+// SendBreak asserts a break condition via TIOCSBRK, holds it for duration,
+// then clears it via TIOCCBRK. This sidesteps TCSBRKP's coarse ~100ms
+// argument granularity in favor of an exact caller-specified duration.
+func (p *linuxPort) SendBreak(duration time.Duration) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TIOCSBRK), 0); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCSBRK)", errno)
+	}
+
+	time.Sleep(duration)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TIOCCBRK), 0); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TIOCCBRK)", errno)
+	}
+
+	return nil
+}
+
+func (p *linuxPort) GetRS485Config() (RS485Config, error) {
+	return getRS485(p.file.Fd())
+}
+
+func (p *linuxPort) SetRS485Config(config RS485Config) error {
+	return setRS485(p.file.Fd(), config)
+}
+
+// Drain blocks until the kernel has finished transmitting all written data,
+// via ioctl(fd, TCSBRK, 1) -- the non-break invocation of TCSBRK is how
+// POSIX tcdrain(3) is implemented on Linux.
+func (p *linuxPort) Drain() error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.file.Fd(), uintptr(unix.TCSBRK), 1); errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL (TCSBRK)", errno)
+	}
+	return nil
+}
+
+// ensureCtxFD lazily opens a second, non-blocking file description on the
+// same device and an eventfd used to wake a blocked poll() on cancellation.
+// A second open (rather than reusing p.file's fd) keeps plain Read on the
+// original blocking fd unaffected by VMIN/VTIME, since O_NONBLOCK is a
+// property of the open file description, not the device.
+func (p *linuxPort) ensureCtxFD() error {
+	p.ctxMu.Lock()
+	defer p.ctxMu.Unlock()
+
+	if p.ctxFD != 0 {
+		return nil
+	}
+
+	ctxFD, openErr := unix.Open(p.file.Name(), unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if openErr != nil {
+		return os.NewSyscallError("open", openErr)
+	}
+
+	cancelFD, eventfdErr := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if eventfdErr != nil {
+		unix.Close(ctxFD)
+		return os.NewSyscallError("eventfd", eventfdErr)
+	}
+
+	p.ctxFD = ctxFD
+	p.cancelFD = cancelFD
+	return nil
+}
+
+func (p *linuxPort) SetReadDeadline(t time.Time) error {
+	p.ctxMu.Lock()
+	p.deadline = t
+	p.ctxMu.Unlock()
+	return nil
+}
+
+// ReadContext reads from the non-blocking ctxFD, using poll() to wait for
+// readability, the configured deadline, or a wakeup on the cancellation
+// eventfd (written to by the goroutine below when ctx is done).
+func (p *linuxPort) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+
+	if err := p.ensureCtxFD(); err != nil {
+		return 0, err
+	}
+
+	p.ctxMu.Lock()
+	ctxFD, cancelFD, deadline := p.ctxFD, p.cancelFD, p.deadline
+	p.ctxMu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			one := uint64(1)
+			unix.Write(cancelFD, (*[8]byte)(unsafe.Pointer(&one))[:])
+		case <-done:
+		}
+	}()
+
+	timeoutMS := -1
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timeoutMS = int((remaining + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	for {
+		n, readErr := unix.Read(ctxFD, b)
+		if readErr == nil && n > 0 {
+			return n, nil
+		}
+		if readErr != nil && readErr != unix.EAGAIN {
+			return 0, os.NewSyscallError("read", readErr)
+		}
+
+		fds := []unix.PollFd{
+			{Fd: int32(ctxFD), Events: unix.POLLIN},
+			{Fd: int32(cancelFD), Events: unix.POLLIN},
+		}
+
+		_, pollErr := unix.Poll(fds, timeoutMS)
+		if pollErr != nil && pollErr != unix.EINTR {
+			return 0, os.NewSyscallError("poll", pollErr)
+		}
+
+		if fds[1].Revents&unix.POLLIN != 0 {
+			var ack [8]byte
+			unix.Read(cancelFD, ack[:])
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+		}
+
+		if pollErr == nil && fds[0].Revents&unix.POLLIN == 0 && fds[1].Revents&unix.POLLIN == 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func openInternal(options OpenOptions) (Port, error) {
+
+	file, openErr :=
+		os.OpenFile(
+			options.PortName,
+			syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
+			0600)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	// Clear the non-blocking flag set above.
+	nonblockErr := syscall.SetNonblock(int(file.Fd()), false)
+	if nonblockErr != nil {
+		return nil, nonblockErr
+	}
+
+	t2, optErr := makeTermios2(options)
+	if optErr != nil {
+		return nil, optErr
+	}
+
+	if setErr := setTermios2(file.Fd(), t2); setErr != nil {
+		return nil, setErr
+	}
+
+	rs485 := effectiveRS485Config(options)
+	if rs485.Enabled {
+		if setErr := setRS485(file.Fd(), rs485); setErr != nil {
+			return nil, setErr
+		}
+	}
+
+	return &linuxPort{file: file, options: options}, nil
+}
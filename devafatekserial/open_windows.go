@@ -0,0 +1,573 @@
+//go:build windows
+
+package devhsmtekserial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procSetCommState        = kernel32.NewProc("SetCommState")
+	procSetCommTimeouts     = kernel32.NewProc("SetCommTimeouts")
+	procSetupComm           = kernel32.NewProc("SetupComm")
+	procGetCommState        = kernel32.NewProc("GetCommState")
+	procPurgeComm           = kernel32.NewProc("PurgeComm")
+	procEscapeCommFunction  = kernel32.NewProc("EscapeCommFunction")
+	procGetCommModemStatus  = kernel32.NewProc("GetCommModemStatus")
+	procSetCommBreak        = kernel32.NewProc("SetCommBreak")
+	procClearCommBreak      = kernel32.NewProc("ClearCommBreak")
+	procFlushFileBuffers    = kernel32.NewProc("FlushFileBuffers")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	// EscapeCommFunction function codes.
+	escSetXoff = 1
+	escSetXon  = 2
+	escSetRTS  = 3
+	escClrRTS  = 4
+	escSetDTR  = 5
+	escClrDTR  = 6
+
+	// GetCommModemStatus bits.
+	msCTSOn  = 0x0010
+	msDSROn  = 0x0020
+	msRingOn = 0x0040
+	msRLSDOn = 0x0080
+
+	// dcb flags, see the Win32 DCB structure documentation. Only the ones
+	// this package actually sets are named; the rest stay reserved (zero).
+	dcbBinary      = 1 << 0
+	dcbParity      = 1 << 1
+	dcbOutXCTSFlow = 1 << 2
+	dcbOutXDSRFlow = 1 << 3
+	dcbDTRControl  = 1 << 4 // two bits, DTR_CONTROL_ENABLE
+
+	// fRtsControl occupies bits 12-13 and holds one of the RTS_CONTROL_*
+	// values (0=DISABLE, 1=ENABLE, 2=HANDSHAKE, 3=TOGGLE), not a single flag
+	// bit, so it's expressed as two pre-shifted alternatives rather than an
+	// ORable bit like the others in this block.
+	dcbRTSControlEnable    = 1 << 12 // RTS_CONTROL_ENABLE
+	dcbRTSControlHandshake = 2 << 12 // RTS_CONTROL_HANDSHAKE
+
+	noParity   = 0
+	oddParity  = 1
+	evenParity = 2
+
+	oneStopBit = 0
+	twoStopBit = 2
+)
+
+// structDCB mirrors the Win32 DCB structure. The layout (and in particular
+// the packed bitfield word) has to match exactly what SetCommState expects.
+type structDCB struct {
+	DCBlength uint32
+	BaudRate  uint32
+
+	flags uint32
+
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// structCommTimeouts mirrors the Win32 COMMTIMEOUTS structure.
+type structCommTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+// makeDCB translates an OpenOptions into the DCB Windows expects to see in
+// SetCommState.
+func makeDCB(options OpenOptions) (*structDCB, error) {
+	dcb := &structDCB{
+		BaudRate: uint32(options.BaudRate),
+		flags:    dcbBinary | dcbDTRControl | dcbRTSControlEnable,
+	}
+	dcb.DCBlength = uint32(unsafe.Sizeof(*dcb))
+
+	switch options.DataBits {
+	case 5, 6, 7, 8:
+		dcb.ByteSize = byte(options.DataBits)
+	default:
+		return nil, errors.New("invalid setting for DataBits")
+	}
+
+	switch options.StopBits {
+	case 1:
+		dcb.StopBits = oneStopBit
+	case 2:
+		dcb.StopBits = twoStopBit
+	default:
+		return nil, errors.New("invalid setting for StopBits")
+	}
+
+	switch options.ParityMode {
+	case PARITY_NONE:
+		dcb.Parity = noParity
+	case PARITY_ODD:
+		dcb.Parity = oddParity
+		dcb.flags |= dcbParity
+	case PARITY_EVEN:
+		dcb.Parity = evenParity
+		dcb.flags |= dcbParity
+	default:
+		return nil, errors.New("invalid setting for ParityMode")
+	}
+
+	if options.RTSCTSFlowControl {
+		dcb.flags |= dcbOutXCTSFlow
+		dcb.flags &^= dcbRTSControlEnable
+		dcb.flags |= dcbRTSControlHandshake
+	}
+
+	if effectiveRS485Config(options).Enabled {
+		return nil, errors.New("RS485 mode is not supported on windows")
+	}
+
+	return dcb, nil
+}
+
+// optionsFromDCB reverses makeDCB, decoding a DCB read back from the driver
+// into the subset of OpenOptions it can represent. Fields the DCB knows
+// nothing about (PortName, the Rs485* settings) are carried over from base
+// unchanged.
+func optionsFromDCB(dcb *structDCB, base OpenOptions) OpenOptions {
+	options := base
+
+	options.BaudRate = uint(dcb.BaudRate)
+	options.DataBits = uint(dcb.ByteSize)
+	options.RTSCTSFlowControl = dcb.flags&dcbOutXCTSFlow != 0
+
+	if dcb.StopBits == twoStopBit {
+		options.StopBits = 2
+	} else {
+		options.StopBits = 1
+	}
+
+	switch dcb.Parity {
+	case oddParity:
+		options.ParityMode = PARITY_ODD
+	case evenParity:
+		options.ParityMode = PARITY_EVEN
+	default:
+		options.ParityMode = PARITY_NONE
+	}
+
+	return options
+}
+
+// makeCommTimeouts translates InterCharacterTimeout/MinimumReadSize into the
+// COMMTIMEOUTS Windows expects. See the discussion on OpenOptions for the
+// semantics this is trying to reproduce on top of a very different API:
+//
+// MinimumReadSize > 0: block ReadFile until at least one byte is available
+// and return it right away (Windows has no direct equivalent of VMIN), by
+// setting ReadIntervalTimeout and ReadTotalTimeoutMultiplier to MAXDWORD and
+// the total timeout constant to MAXDWORD-1 milliseconds (about 49 days).
+//
+// InterCharacterTimeout > 0 and MinimumReadSize == 0: return a short read as
+// soon as ReadIntervalTimeout milliseconds pass with no new byte, by setting
+// ReadIntervalTimeout and the total timeout constant to the same value.
+func makeCommTimeouts(options OpenOptions) structCommTimeouts {
+	if options.MinimumReadSize > 0 {
+		const maxDWORD = ^uint32(0)
+		return structCommTimeouts{
+			ReadIntervalTimeout:        maxDWORD,
+			ReadTotalTimeoutConstant:   maxDWORD - 1,
+			ReadTotalTimeoutMultiplier: maxDWORD,
+		}
+	}
+
+	timeout := uint32(options.InterCharacterTimeout)
+	return structCommTimeouts{
+		ReadIntervalTimeout:      timeout,
+		ReadTotalTimeoutConstant: timeout,
+	}
+}
+
+// windowsPort is the Windows implementation of Port. It wraps the raw Win32
+// file handle and the OpenOptions last applied to it, so that the SetXxx
+// methods can recompute a full DCB from a single changed field.
+type windowsPort struct {
+	mu      sync.Mutex
+	handle  syscall.Handle
+	options OpenOptions
+
+	deadline time.Time
+}
+
+// ioOverlapped issues an overlapped ReadFile or WriteFile and blocks until it
+// completes. The handle was opened with FILE_FLAG_OVERLAPPED, which requires
+// every I/O request to carry a valid OVERLAPPED; passing nil is unsupported
+// and can report completion before the transfer has actually finished.
+func (p *windowsPort) ioOverlapped(b []byte, write bool) (int, error) {
+	eventHandle, eventErr := createEvent()
+	if eventErr != nil {
+		return 0, eventErr
+	}
+	defer syscall.CloseHandle(eventHandle)
+
+	overlapped := &syscall.Overlapped{HEvent: eventHandle}
+
+	var n uint32
+	var opErr error
+	if write {
+		opErr = syscall.WriteFile(p.handle, b, &n, overlapped)
+	} else {
+		opErr = syscall.ReadFile(p.handle, b, &n, overlapped)
+	}
+	if opErr != nil && opErr != syscall.ERROR_IO_PENDING {
+		return 0, opErr
+	}
+
+	if resErr := getOverlappedResult(p.handle, overlapped, &n, true); resErr != nil {
+		return int(n), resErr
+	}
+	return int(n), nil
+}
+
+func (p *windowsPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ioOverlapped(b, false)
+}
+
+func (p *windowsPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ioOverlapped(b, true)
+}
+
+func (p *windowsPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return syscall.CloseHandle(p.handle)
+}
+
+// apply recomputes a DCB and COMMTIMEOUTS from options and pushes them down
+// via SetCommState/SetCommTimeouts.
+func (p *windowsPort) apply(options OpenOptions) error {
+	dcb, optErr := makeDCB(options)
+	if optErr != nil {
+		return optErr
+	}
+
+	if r, _, err := procSetCommState.Call(uintptr(p.handle), uintptr(unsafe.Pointer(dcb))); r == 0 {
+		return os.NewSyscallError("SetCommState", err)
+	}
+
+	timeouts := makeCommTimeouts(options)
+	if r, _, err := procSetCommTimeouts.Call(uintptr(p.handle), uintptr(unsafe.Pointer(&timeouts))); r == 0 {
+		return os.NewSyscallError("SetCommTimeouts", err)
+	}
+
+	p.options = options
+	return nil
+}
+
+func (p *windowsPort) SetBaudRate(baudRate uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.BaudRate = baudRate
+	return p.apply(options)
+}
+
+func (p *windowsPort) SetParity(parityMode ParityMode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.ParityMode = parityMode
+	return p.apply(options)
+}
+
+func (p *windowsPort) SetStopBits(stopBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.StopBits = stopBits
+	return p.apply(options)
+}
+
+func (p *windowsPort) SetDataBits(dataBits uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	return p.apply(options)
+}
+
+func (p *windowsPort) SetTimeouts(interCharacterTimeout, minimumReadSize uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.InterCharacterTimeout = interCharacterTimeout
+	options.MinimumReadSize = minimumReadSize
+	return p.apply(options)
+}
+
+func (p *windowsPort) GetTermios() (OpenOptions, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dcb := &structDCB{DCBlength: uint32(unsafe.Sizeof(structDCB{}))}
+	if r, _, err := procGetCommState.Call(uintptr(p.handle), uintptr(unsafe.Pointer(dcb))); r == 0 {
+		return OpenOptions{}, os.NewSyscallError("GetCommState", err)
+	}
+
+	return optionsFromDCB(dcb, p.options), nil
+}
+
+// escapeCommFunction issues one of the EscapeCommFunction function codes
+// (SETDTR/CLRDTR/SETRTS/CLRRTS) against the port.
+func (p *windowsPort) escapeCommFunction(fn uintptr) error {
+	if r, _, err := procEscapeCommFunction.Call(uintptr(p.handle), fn); r == 0 {
+		return os.NewSyscallError("EscapeCommFunction", err)
+	}
+	return nil
+}
+
+func (p *windowsPort) SetDTR(on bool) error {
+	if on {
+		return p.escapeCommFunction(escSetDTR)
+	}
+	return p.escapeCommFunction(escClrDTR)
+}
+
+func (p *windowsPort) SetRTS(on bool) error {
+	if on {
+		return p.escapeCommFunction(escSetRTS)
+	}
+	return p.escapeCommFunction(escClrRTS)
+}
+
+// modemStatus reads the modem-control line bits via GetCommModemStatus.
+func (p *windowsPort) modemStatus() (uint32, error) {
+	var status uint32
+	if r, _, err := procGetCommModemStatus.Call(uintptr(p.handle), uintptr(unsafe.Pointer(&status))); r == 0 {
+		return 0, os.NewSyscallError("GetCommModemStatus", err)
+	}
+	return status, nil
+}
+
+func (p *windowsPort) GetCTS() (bool, error) {
+	status, err := p.modemStatus()
+	return status&msCTSOn != 0, err
+}
+
+func (p *windowsPort) GetDSR() (bool, error) {
+	status, err := p.modemStatus()
+	return status&msDSROn != 0, err
+}
+
+func (p *windowsPort) GetRI() (bool, error) {
+	status, err := p.modemStatus()
+	return status&msRingOn != 0, err
+}
+
+func (p *windowsPort) GetCD() (bool, error) {
+	status, err := p.modemStatus()
+	return status&msRLSDOn != 0, err
+}
+
+// SendBreak asserts a break condition via SetCommBreak, holds it for
+// duration, then clears it via ClearCommBreak.
+func (p *windowsPort) SendBreak(duration time.Duration) error {
+	if r, _, err := procSetCommBreak.Call(uintptr(p.handle)); r == 0 {
+		return os.NewSyscallError("SetCommBreak", err)
+	}
+
+	time.Sleep(duration)
+
+	if r, _, err := procClearCommBreak.Call(uintptr(p.handle)); r == 0 {
+		return os.NewSyscallError("ClearCommBreak", err)
+	}
+
+	return nil
+}
+
+func (p *windowsPort) GetRS485Config() (RS485Config, error) {
+	return RS485Config{}, errors.New("RS485 mode is not supported on windows")
+}
+
+func (p *windowsPort) SetRS485Config(RS485Config) error {
+	return errors.New("RS485 mode is not supported on windows")
+}
+
+// Drain blocks until all buffered data has reached the device, via
+// FlushFileBuffers.
+func (p *windowsPort) Drain() error {
+	if r, _, err := procFlushFileBuffers.Call(uintptr(p.handle)); r == 0 {
+		return os.NewSyscallError("FlushFileBuffers", err)
+	}
+	return nil
+}
+
+// createEvent creates a manual-reset, initially-unsignaled Win32 event,
+// used as the completion signal for an overlapped ReadFile.
+func createEvent() (syscall.Handle, error) {
+	r, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if r == 0 {
+		return 0, os.NewSyscallError("CreateEventW", err)
+	}
+	return syscall.Handle(r), nil
+}
+
+// getOverlappedResult waits for (if wait is true) and retrieves the result
+// of a previously-issued overlapped I/O request.
+func getOverlappedResult(handle syscall.Handle, overlapped *syscall.Overlapped, transferred *uint32, wait bool) error {
+	var waitArg uintptr
+	if wait {
+		waitArg = 1
+	}
+
+	r, _, err := procGetOverlappedResult.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(overlapped)),
+		uintptr(unsafe.Pointer(transferred)),
+		waitArg)
+	if r == 0 {
+		return os.NewSyscallError("GetOverlappedResult", err)
+	}
+	return nil
+}
+
+func (p *windowsPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	return nil
+}
+
+// ReadContext issues an overlapped ReadFile and waits for it to complete,
+// the deadline set by SetReadDeadline to elapse, or ctx to be done --
+// cancelling the pending read via CancelIoEx in the latter two cases. The
+// handle was opened with FILE_FLAG_OVERLAPPED in openInternal specifically
+// to make this possible.
+func (p *windowsPort) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+
+	p.mu.Lock()
+	handle, deadline := p.handle, p.deadline
+	p.mu.Unlock()
+
+	eventHandle, eventErr := createEvent()
+	if eventErr != nil {
+		return 0, eventErr
+	}
+	defer syscall.CloseHandle(eventHandle)
+
+	overlapped := &syscall.Overlapped{HEvent: eventHandle}
+
+	var n uint32
+	readErr := syscall.ReadFile(handle, b, &n, overlapped)
+	if readErr != nil && readErr != syscall.ERROR_IO_PENDING {
+		return 0, readErr
+	}
+
+	timeoutMS := uint32(syscall.INFINITE)
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			syscall.CancelIoEx(handle, overlapped)
+			getOverlappedResult(handle, overlapped, &n, true)
+			return 0, os.ErrDeadlineExceeded
+		}
+		timeoutMS = uint32((remaining + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.CancelIoEx(handle, overlapped)
+		case <-done:
+		}
+	}()
+
+	event, waitErr := syscall.WaitForSingleObject(eventHandle, timeoutMS)
+	if waitErr != nil {
+		syscall.CancelIoEx(handle, overlapped)
+		getOverlappedResult(handle, overlapped, &n, true)
+		return 0, os.NewSyscallError("WaitForSingleObject", waitErr)
+	}
+
+	if event == syscall.WAIT_TIMEOUT {
+		syscall.CancelIoEx(handle, overlapped)
+		getOverlappedResult(handle, overlapped, &n, true)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if resErr := getOverlappedResult(handle, overlapped, &n, true); resErr != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, resErr
+	}
+
+	return int(n), nil
+}
+
+func openInternal(options OpenOptions) (Port, error) {
+
+	path, pathErr := syscall.UTF16PtrFromString(options.PortName)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+
+	handle, openErr := syscall.CreateFile(
+		path,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
+		0)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	port := &windowsPort{handle: handle}
+	if applyErr := port.apply(options); applyErr != nil {
+		syscall.CloseHandle(handle)
+		return nil, applyErr
+	}
+
+	return port, nil
+}
@@ -1,214 +1,12 @@
 package devhsmtekserial
 
 import (
-	"errors"
+	"context"
 	"io"
 	"math"
-	"os"
-	"syscall"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
-)
-
-//
-// Grab the constants with the following little program, to avoid using cgo:
-//
-// #include <stdio.h>
-// #include <stdlib.h>
-// #include <linux/termios.h>
-//
-// int main(int argc, const char **argv) {
-//   printf("TCSETS2 = 0x%08X\n", TCSETS2);
-//   printf("BOTHER  = 0x%08X\n", BOTHER);
-//   printf("NCCS    = %d\n",     NCCS);
-//   return 0;
-// }
-//
-const (
-	kTCSETS2 = 0x402C542B
-	kBOTHER  = 0x1000
-	kNCCS    = 19
+	"time"
 )
 
-//
-// Types from asm-generic/termbits.h
-//
-
-type cc_t byte
-type speed_t uint32
-type tcflag_t uint32
-type termios2 struct {
-	c_iflag  tcflag_t    // input mode flags
-	c_oflag  tcflag_t    // output mode flags
-	c_cflag  tcflag_t    // control mode flags
-	c_lflag  tcflag_t    // local mode flags
-	c_line   cc_t        // line discipline
-	c_cc     [kNCCS]cc_t // control characters
-	c_ispeed speed_t     // input speed
-	c_ospeed speed_t     // output speed
-}
-
-// Constants for RS485 operation
-
-const (
-	sER_RS485_ENABLED        = (1 << 0)
-	sER_RS485_RTS_ON_SEND    = (1 << 1)
-	sER_RS485_RTS_AFTER_SEND = (1 << 2)
-	sER_RS485_RX_DURING_TX   = (1 << 4)
-	tIOCSRS485               = 0x542F
-)
-
-type serial_rs485 struct {
-	flags                 uint32
-	delay_rts_before_send uint32
-	delay_rts_after_send  uint32
-	padding               [5]uint32
-}
-
-//
-// Returns a pointer to an instantiates termios2 struct, based on the given
-// OpenOptions. Termios2 is a Linux extension which allows arbitrary baud rates
-// to be specified.
-//
-func makeTermios2(options OpenOptions) (*termios2, error) {
-
-	// Sanity check inter-character timeout and minimum read size options.
-
-	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
-	vmin := options.MinimumReadSize
-
-	if vmin == 0 && vtime < 100 {
-		return nil, errors.New("invalid values for InterCharacterTimeout and MinimumReadSize")
-	}
-
-	if vtime > 25500 {
-		return nil, errors.New("invalid value for InterCharacterTimeout")
-	}
-
-	ccOpts := [kNCCS]cc_t{}
-	ccOpts[syscall.VTIME] = cc_t(vtime / 100)
-	ccOpts[syscall.VMIN] = cc_t(vmin)
-
-	t2 := &termios2{
-		c_cflag:  syscall.CLOCAL | syscall.CREAD | kBOTHER,
-		c_ispeed: speed_t(options.BaudRate),
-		c_ospeed: speed_t(options.BaudRate),
-		c_cc:     ccOpts,
-	}
-
-	switch options.StopBits {
-	case 1:
-	case 2:
-		t2.c_cflag |= syscall.CSTOPB
-
-	default:
-		return nil, errors.New("invalid setting for StopBits")
-	}
-
-	switch options.ParityMode {
-	case PARITY_NONE:
-	case PARITY_ODD:
-		t2.c_cflag |= syscall.PARENB
-		t2.c_cflag |= syscall.PARODD
-
-	case PARITY_EVEN:
-		t2.c_cflag |= syscall.PARENB
-
-	default:
-		return nil, errors.New("invalid setting for ParityMode")
-	}
-
-	switch options.DataBits {
-	case 5:
-		t2.c_cflag |= syscall.CS5
-	case 6:
-		t2.c_cflag |= syscall.CS6
-	case 7:
-		t2.c_cflag |= syscall.CS7
-	case 8:
-		t2.c_cflag |= syscall.CS8
-	default:
-		return nil, errors.New("invalid setting for DataBits")
-	}
-
-	if options.RTSCTSFlowControl {
-		t2.c_cflag |= unix.CRTSCTS
-	}
-
-	return t2, nil
-}
-
-func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
-
-	file, openErr :=
-		os.OpenFile(
-			options.PortName,
-			syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
-			0600)
-	if openErr != nil {
-		return nil, openErr
-	}
-
-	// Clear the non-blocking flag set above.
-	nonblockErr := syscall.SetNonblock(int(file.Fd()), false)
-	if nonblockErr != nil {
-		return nil, nonblockErr
-	}
-
-	t2, optErr := makeTermios2(options)
-	if optErr != nil {
-		return nil, optErr
-	}
-
-	r, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		uintptr(file.Fd()),
-		uintptr(kTCSETS2),
-		uintptr(unsafe.Pointer(t2)))
-
-	if errno != 0 {
-		return nil, os.NewSyscallError("SYS_IOCTL", errno)
-	}
-
-	if r != 0 {
-		return nil, errors.New("unknown error from SYS_IOCTL")
-	}
-
-	if options.Rs485Enable {
-		rs485 := serial_rs485{
-			sER_RS485_ENABLED,
-			uint32(options.Rs485DelayRtsBeforeSend),
-			uint32(options.Rs485DelayRtsAfterSend),
-			[5]uint32{0, 0, 0, 0, 0},
-		}
-
-		if options.Rs485RtsHighDuringSend {
-			rs485.flags |= sER_RS485_RTS_ON_SEND
-		}
-
-		if options.Rs485RtsHighAfterSend {
-			rs485.flags |= sER_RS485_RTS_AFTER_SEND
-		}
-
-		r, _, errno := syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(file.Fd()),
-			uintptr(tIOCSRS485),
-			uintptr(unsafe.Pointer(&rs485)))
-
-		if errno != 0 {
-			return nil, os.NewSyscallError("SYS_IOCTL (RS485)", errno)
-		}
-
-		if r != 0 {
-			return nil, errors.New("Unknown error from SYS_IOCTL (RS485)")
-		}
-	}
-
-	return file, nil
-}
-
 // Valid parity values.
 type ParityMode int
 
@@ -327,27 +125,163 @@ type OpenOptions struct {
 	InterCharacterTimeout uint
 	MinimumReadSize       uint
 
-	// Use to enable RS485 mode -- probably only valid on some Linux platforms
+	// RS485 configures RS485 (software-controlled transceiver direction)
+	// mode -- probably only valid on some Linux platforms. This supersedes
+	// the flat Rs485* fields below; if RS485.Enabled is true, RS485 is used
+	// and the flat fields are ignored.
+	RS485 RS485Config
+
+	// Deprecated: use RS485.Enabled instead.
 	Rs485Enable bool
 
-	// Set to true for logic level high during send
+	// Deprecated: use RS485.RTSOnSend instead.
 	Rs485RtsHighDuringSend bool
 
-	// Set to true for logic level high after send
+	// Deprecated: use RS485.RTSAfterSend instead.
 	Rs485RtsHighAfterSend bool
 
-	// set to receive data during sending
+	// Deprecated: use RS485.RxDuringTx instead.
 	Rs485RxDuringTx bool
 
-	// RTS delay before send
+	// Deprecated: use RS485.DelayRTSBeforeSend instead.
 	Rs485DelayRtsBeforeSend int
 
-	// RTS delay after send
+	// Deprecated: use RS485.DelayRTSAfterSend instead.
 	Rs485DelayRtsAfterSend int
 }
 
-// Open creates an io.ReadWriteCloser based on the supplied options struct.
-func Open(options OpenOptions) (io.ReadWriteCloser, error) {
+// RS485Config describes the RS485 transceiver-direction settings for a
+// serial port: whether RTS should be toggled around a send to switch a
+// half-duplex transceiver between transmit and receive, and how long to
+// hold RTS before/after the UART actually shifts the data out.
+type RS485Config struct {
+	// Enabled switches RS485 (software-controlled direction) mode on.
+	Enabled bool
+
+	// RTSOnSend drives RTS high while transmitting.
+	RTSOnSend bool
+
+	// RTSAfterSend drives RTS high after transmitting finishes.
+	RTSAfterSend bool
+
+	// RxDuringTx allows the receiver to stay enabled while transmitting,
+	// needed by transceivers that echo the local transmission back.
+	RxDuringTx bool
+
+	// DelayRTSBeforeSend is the delay, in milliseconds, between asserting
+	// RTS and the UART actually starting transmission.
+	DelayRTSBeforeSend int
+
+	// DelayRTSAfterSend is the delay, in milliseconds, between the UART
+	// finishing transmission and de-asserting RTS.
+	DelayRTSAfterSend int
+}
+
+// effectiveRS485Config resolves the RS485 settings to use for options,
+// preferring the RS485 field and falling back to the deprecated flat
+// Rs485* fields for callers that haven't migrated yet.
+func effectiveRS485Config(options OpenOptions) RS485Config {
+	if options.RS485.Enabled {
+		return options.RS485
+	}
+
+	return RS485Config{
+		Enabled:            options.Rs485Enable,
+		RTSOnSend:          options.Rs485RtsHighDuringSend,
+		RTSAfterSend:       options.Rs485RtsHighAfterSend,
+		RxDuringTx:         options.Rs485RxDuringTx,
+		DelayRTSBeforeSend: options.Rs485DelayRtsBeforeSend,
+		DelayRTSAfterSend:  options.Rs485DelayRtsAfterSend,
+	}
+}
+
+// Port is the interface returned by Open. Beyond plain io.ReadWriteCloser
+// behavior, it lets callers reconfigure a few settings at runtime without
+// closing and reopening the underlying port -- useful for devices that
+// renegotiate speed or framing after the connection is already up (modems,
+// GPS receivers, bootloaders).
+type Port interface {
+	io.ReadWriteCloser
+
+	// SetBaudRate changes the baud rate of an already-open port.
+	SetBaudRate(baudRate uint) error
+
+	// SetParity changes the parity mode of an already-open port.
+	SetParity(parityMode ParityMode) error
+
+	// SetStopBits changes the number of stop bits of an already-open port.
+	SetStopBits(stopBits uint) error
+
+	// SetDataBits changes the number of data bits of an already-open port.
+	SetDataBits(dataBits uint) error
+
+	// SetTimeouts changes the InterCharacterTimeout/MinimumReadSize pair of
+	// an already-open port; see the OpenOptions docs for their semantics.
+	SetTimeouts(interCharacterTimeout, minimumReadSize uint) error
+
+	// GetTermios reads back the port's current settings.
+	GetTermios() (OpenOptions, error)
+
+	// SetDTR raises or lowers the DTR (Data Terminal Ready) modem-control
+	// line. Many USB-serial boards (e.g. Arduino) wire DTR to a reset line.
+	SetDTR(on bool) error
+
+	// SetRTS raises or lowers the RTS (Request To Send) modem-control line.
+	// This is distinct from the RS485 auto-RTS handling in RS485Config: it
+	// is a direct, explicit toggle for callers driving the line themselves.
+	SetRTS(on bool) error
+
+	// GetCTS reports the current state of the CTS (Clear To Send) line.
+	GetCTS() (bool, error)
+
+	// GetDSR reports the current state of the DSR (Data Set Ready) line.
+	GetDSR() (bool, error)
+
+	// GetRI reports the current state of the RI (Ring Indicator) line.
+	GetRI() (bool, error)
+
+	// GetCD reports the current state of the CD (Carrier Detect) line.
+	GetCD() (bool, error)
+
+	// SendBreak asserts a break condition on the line for the given
+	// duration, then clears it.
+	SendBreak(duration time.Duration) error
+
+	// GetRS485Config reads back the port's current RS485 settings, letting
+	// callers confirm the driver accepted what they asked for -- some UART
+	// drivers silently clamp delays or ignore flags.
+	GetRS485Config() (RS485Config, error)
+
+	// SetRS485Config changes the port's RS485 settings at runtime, e.g. to
+	// flip a half-duplex Modbus-RTU transceiver's direction per frame.
+	SetRS485Config(config RS485Config) error
+
+	// Drain blocks until all written data has been transmitted by the
+	// driver, e.g. so a caller can safely lower RTS after a send on a
+	// software-controlled half-duplex transceiver.
+	Drain() error
+
+	// ReadContext reads into p the same way Read does, but without being
+	// subject to the InterCharacterTimeout/MinimumReadSize constraints
+	// documented on OpenOptions: it returns as soon as at least one byte is
+	// available, ctx is done, or the deadline set by SetReadDeadline
+	// elapses, whichever comes first. A cancelled ctx makes the call return
+	// ctx.Err() promptly, which a plain Read blocked on VTIME cannot do.
+	// On Windows the underlying ReadFile still follows the COMMTIMEOUTS set
+	// from InterCharacterTimeout/MinimumReadSize, so when those expire first
+	// ReadContext can return 0 bytes and a nil error.
+	ReadContext(ctx context.Context, p []byte) (int, error)
+
+	// SetReadDeadline sets the deadline for future ReadContext calls,
+	// analogous to net.Conn's SetReadDeadline. A zero Time means
+	// ReadContext blocks until data arrives or ctx is done, with no
+	// additional deadline. Once the deadline elapses, ReadContext returns
+	// an error satisfying errors.Is(err, os.ErrDeadlineExceeded).
+	SetReadDeadline(t time.Time) error
+}
+
+// Open creates a Port based on the supplied options struct.
+func Open(options OpenOptions) (Port, error) {
 	// Redirect to the OS-specific function.
 	return openInternal(options)
 }
@@ -0,0 +1,274 @@
+// Package modbus implements a Modbus RTU master transport on top of the
+// devafatekserial serial package, handling PDU framing, CRC-16, and the
+// spec's inter-frame silence so callers can talk to RTU slaves without
+// reimplementing the wire protocol themselves.
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	serial "github.com/husamettinarabaci/serial_lib_go/devafatekserial"
+)
+
+// Function codes this client supports.
+const (
+	fnReadCoils              = 0x01
+	fnReadHoldingRegisters   = 0x03
+	fnWriteSingleRegister    = 0x06
+	fnWriteMultipleRegisters = 0x10
+)
+
+const exceptionBit = 0x80
+
+// Config controls how a Client frames traffic on top of an already-open
+// serial port.
+type Config struct {
+	// BaudRate must match the port's configured baud rate; it's used to
+	// derive the inter-frame silence (t3.5) observed before each request.
+	BaudRate uint
+
+	// ResponseTimeout bounds how long a request waits for a slave's
+	// response. Defaults to 1s if zero.
+	ResponseTimeout time.Duration
+
+	// RS485, if Enabled, tells Client the port's UART is already doing
+	// hardware RTS switching (via serial.RS485Config / SetRS485Config), so
+	// Client leaves direction control alone.
+	RS485 serial.RS485Config
+
+	// SoftwareRTSControl tells Client to drive RTS itself around each
+	// write -- SetRTS(true), write, Drain(), SetRTS(false) -- for
+	// transceivers wired to a GPIO/RTS line without hardware RS485 support.
+	// Ignored when RS485.Enabled is true.
+	SoftwareRTSControl bool
+}
+
+// Client is a Modbus RTU master built on top of a serial.Port.
+type Client struct {
+	port            serial.Port
+	softwareRTS     bool
+	t35             time.Duration
+	t15             time.Duration
+	responseTimeout time.Duration
+}
+
+// NewClient wraps an already-open serial.Port for Modbus RTU framing. Frame
+// timing (t3.5 between requests, t1.5 to detect end-of-frame) is enforced by
+// Client itself via ReadContext/SetReadDeadline, so the port's
+// InterCharacterTimeout/MinimumReadSize don't need to be set for Client's
+// benefit; see FrameTimeoutOptions if something else reads the port directly.
+func NewClient(port serial.Port, config Config) *Client {
+	responseTimeout := config.ResponseTimeout
+	if responseTimeout == 0 {
+		responseTimeout = time.Second
+	}
+
+	return &Client{
+		port:            port,
+		softwareRTS:     config.SoftwareRTSControl && !config.RS485.Enabled,
+		t35:             interFrameSilence(config.BaudRate),
+		t15:             intraFrameGap(config.BaudRate),
+		responseTimeout: responseTimeout,
+	}
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting at address
+// from the slave at slaveID (function code 0x03).
+func (c *Client) ReadHoldingRegisters(slaveID byte, address, quantity uint16) ([]uint16, error) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], address)
+	binary.BigEndian.PutUint16(payload[2:4], quantity)
+
+	response, err := c.request(slaveID, fnReadHoldingRegisters, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < 1 || int(response[0]) != 2*int(quantity) {
+		return nil, fmt.Errorf("modbus: malformed ReadHoldingRegisters response")
+	}
+
+	registers := make([]uint16, quantity)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(response[1+2*i : 3+2*i])
+	}
+
+	return registers, nil
+}
+
+// ReadCoils reads quantity coils starting at address from the slave at
+// slaveID (function code 0x01).
+func (c *Client) ReadCoils(slaveID byte, address, quantity uint16) ([]bool, error) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], address)
+	binary.BigEndian.PutUint16(payload[2:4], quantity)
+
+	response, err := c.request(slaveID, fnReadCoils, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	byteCount := (int(quantity) + 7) / 8
+	if len(response) < 1+byteCount || int(response[0]) != byteCount {
+		return nil, fmt.Errorf("modbus: malformed ReadCoils response")
+	}
+
+	coils := make([]bool, quantity)
+	for i := range coils {
+		coils[i] = response[1+i/8]&(1<<uint(i%8)) != 0
+	}
+
+	return coils, nil
+}
+
+// WriteSingleRegister writes value to the holding register at address on
+// the slave at slaveID (function code 0x06).
+func (c *Client) WriteSingleRegister(slaveID byte, address, value uint16) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], address)
+	binary.BigEndian.PutUint16(payload[2:4], value)
+
+	_, err := c.request(slaveID, fnWriteSingleRegister, payload)
+	return err
+}
+
+// WriteMultipleRegisters writes values to consecutive holding registers
+// starting at address on the slave at slaveID (function code 0x10).
+func (c *Client) WriteMultipleRegisters(slaveID byte, address uint16, values []uint16) error {
+	payload := make([]byte, 5+2*len(values))
+	binary.BigEndian.PutUint16(payload[0:2], address)
+	binary.BigEndian.PutUint16(payload[2:4], uint16(len(values)))
+	payload[4] = byte(2 * len(values))
+
+	for i, value := range values {
+		binary.BigEndian.PutUint16(payload[5+2*i:7+2*i], value)
+	}
+
+	_, err := c.request(slaveID, fnWriteMultipleRegisters, payload)
+	return err
+}
+
+// request sends a framed PDU to slaveID and returns the verified response
+// PDU (with the function code and any trailing CRC already stripped).
+func (c *Client) request(slaveID byte, functionCode byte, payload []byte) ([]byte, error) {
+	// Give the bus t3.5 to go quiet before we start a new request, per spec.
+	time.Sleep(c.t35)
+
+	frame := make([]byte, 0, 2+len(payload)+2)
+	frame = append(frame, slaveID, functionCode)
+	frame = append(frame, payload...)
+
+	crc := crc16(frame)
+	frame = append(frame, byte(crc), byte(crc>>8))
+
+	if err := c.writeFrame(frame); err != nil {
+		return nil, err
+	}
+
+	response, err := c.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseResponse(slaveID, functionCode, response)
+}
+
+// writeFrame sends frame, toggling RTS around the write when Client is
+// configured for software-controlled direction switching.
+func (c *Client) writeFrame(frame []byte) error {
+	if c.softwareRTS {
+		if err := c.port.SetRTS(true); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.port.Write(frame); err != nil {
+		return err
+	}
+
+	if c.softwareRTS {
+		if err := c.port.Drain(); err != nil {
+			return err
+		}
+		if err := c.port.SetRTS(false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFrame reads one response frame via ReadContext/SetReadDeadline, so a
+// slave that never replies (or stalls mid-frame) can't leak a goroutine
+// blocked on the underlying fd to race against the next request's read.
+// c.responseTimeout bounds the wait for the first byte; once a byte has
+// arrived, c.t15 (the spec's t1.5 intra-frame gap) bounds the wait for each
+// subsequent one, so a gap that size is treated as the end of the frame.
+func (c *Client) readFrame() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.responseTimeout)
+	defer cancel()
+
+	buf := make([]byte, maxRTUFrameSize)
+	n := 0
+
+	for n < len(buf) {
+		deadline := time.Time{}
+		if n > 0 {
+			deadline = time.Now().Add(c.t15)
+		}
+		if err := c.port.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		read, err := c.port.ReadContext(ctx, buf[n:])
+		n += read
+		if err != nil {
+			if n > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, errors.New("modbus: response timeout")
+			}
+			return nil, err
+		}
+	}
+
+	return buf[:n], nil
+}
+
+// parseResponse validates a response frame's address, CRC, and function
+// code, translating a Modbus exception response into an error, and returns
+// the PDU's data bytes (after the function code, before the CRC).
+func parseResponse(slaveID, functionCode byte, frame []byte) ([]byte, error) {
+	if len(frame) < 4 {
+		return nil, fmt.Errorf("modbus: short response frame (%d bytes)", len(frame))
+	}
+
+	data, gotCRC := frame[:len(frame)-2], frame[len(frame)-2:]
+	wantCRC := crc16(data)
+	if gotCRC[0] != byte(wantCRC) || gotCRC[1] != byte(wantCRC>>8) {
+		return nil, errors.New("modbus: CRC mismatch in response")
+	}
+
+	if data[0] != slaveID {
+		return nil, fmt.Errorf("modbus: response from slave %d, expected %d", data[0], slaveID)
+	}
+
+	if data[1] == functionCode|exceptionBit {
+		if len(data) < 3 {
+			return nil, errors.New("modbus: malformed exception response")
+		}
+		return nil, fmt.Errorf("modbus: slave %d returned exception code 0x%02X", slaveID, data[2])
+	}
+
+	if data[1] != functionCode {
+		return nil, fmt.Errorf("modbus: response function code 0x%02X, expected 0x%02X", data[1], functionCode)
+	}
+
+	return data[2:], nil
+}
@@ -0,0 +1,63 @@
+package modbus
+
+import "testing"
+
+// frame builds a Modbus RTU frame from data and appends its CRC, mirroring
+// what Client.request does before writing to the wire.
+func frame(data ...byte) []byte {
+	crc := crc16(data)
+	return append(append([]byte{}, data...), byte(crc), byte(crc>>8))
+}
+
+func TestParseResponse(t *testing.T) {
+	t.Run("valid response", func(t *testing.T) {
+		response := frame(0x01, fnReadHoldingRegisters, 0x02, 0x00, 0x2A)
+
+		data, err := parseResponse(0x01, fnReadHoldingRegisters, response)
+		if err != nil {
+			t.Fatalf("parseResponse() error = %v", err)
+		}
+		if want := []byte{0x02, 0x00, 0x2A}; string(data) != string(want) {
+			t.Errorf("parseResponse() = %X, want %X", data, want)
+		}
+	})
+
+	t.Run("short frame", func(t *testing.T) {
+		if _, err := parseResponse(0x01, fnReadHoldingRegisters, []byte{0x01, 0x03}); err == nil {
+			t.Error("parseResponse() error = nil, want error for short frame")
+		}
+	})
+
+	t.Run("CRC mismatch", func(t *testing.T) {
+		response := frame(0x01, fnReadHoldingRegisters, 0x02, 0x00, 0x2A)
+		response[len(response)-1] ^= 0xFF
+
+		if _, err := parseResponse(0x01, fnReadHoldingRegisters, response); err == nil {
+			t.Error("parseResponse() error = nil, want CRC mismatch error")
+		}
+	})
+
+	t.Run("wrong slave ID", func(t *testing.T) {
+		response := frame(0x02, fnReadHoldingRegisters, 0x02, 0x00, 0x2A)
+
+		if _, err := parseResponse(0x01, fnReadHoldingRegisters, response); err == nil {
+			t.Error("parseResponse() error = nil, want slave ID mismatch error")
+		}
+	})
+
+	t.Run("exception response", func(t *testing.T) {
+		response := frame(0x01, fnReadHoldingRegisters|exceptionBit, 0x02)
+
+		if _, err := parseResponse(0x01, fnReadHoldingRegisters, response); err == nil {
+			t.Error("parseResponse() error = nil, want exception error")
+		}
+	})
+
+	t.Run("unexpected function code", func(t *testing.T) {
+		response := frame(0x01, fnReadCoils, 0x01, 0xFF)
+
+		if _, err := parseResponse(0x01, fnReadHoldingRegisters, response); err == nil {
+			t.Error("parseResponse() error = nil, want function code mismatch error")
+		}
+	})
+}
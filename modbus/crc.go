@@ -0,0 +1,21 @@
+package modbus
+
+// crc16 computes the Modbus CRC-16 checksum: polynomial 0xA001 (the
+// bit-reflected form of 0x8005), initial value 0xFFFF, no final XOR.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+
+	for _, b := range data {
+		crc ^= uint16(b)
+
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,24 @@
+package modbus
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		// The CRC-16/MODBUS check value for the catalogue string "123456789".
+		{"check string", []byte("123456789"), 0x4B37},
+		// ReadHoldingRegisters(slave=1, address=0, quantity=10).
+		{"read holding registers request", []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}, 0xCDC5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := crc16(c.data); got != c.want {
+				t.Errorf("crc16(%X) = %#04X, want %#04X", c.data, got, c.want)
+			}
+		})
+	}
+}
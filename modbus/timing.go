@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"math"
+	"time"
+)
+
+// maxRTUFrameSize is the largest possible Modbus RTU frame: a 1-byte
+// address, a 253-byte PDU, and a 2-byte CRC.
+const maxRTUFrameSize = 256
+
+// interFrameSilence returns t3.5, the minimum silence the Modbus RTU spec
+// requires between frames, for the given baud rate: 3.5 character times, or
+// a fixed 1.75ms above 19200 baud where character time becomes too short to
+// reliably detect. A character is 11 bits (1 start + 8 data + 1 parity/none
+// + 1 stop, the spec's conservative assumption regardless of the port's
+// actual framing).
+func interFrameSilence(baudRate uint) time.Duration {
+	if baudRate == 0 {
+		baudRate = 9600
+	}
+
+	if baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+
+	seconds := math.Max(3.5*11/float64(baudRate), 0.00175)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// intraFrameGap returns t1.5, the maximum gap allowed between characters
+// within a single frame before a receiver must treat it as corrupt.
+func intraFrameGap(baudRate uint) time.Duration {
+	if baudRate == 0 {
+		baudRate = 9600
+	}
+
+	if baudRate > 19200 {
+		return 750 * time.Microsecond
+	}
+
+	seconds := math.Max(1.5*11/float64(baudRate), 0.00075)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// FrameTimeoutOptions returns the InterCharacterTimeout/MinimumReadSize pair
+// that makes a plain Read() on serial.OpenOptions return a complete RTU
+// frame: MinimumReadSize is 255, the largest value the 8-bit termios VMIN
+// field can hold, so VMIN ends the read early only for a maximum-size
+// 256-byte frame, and InterCharacterTimeout is t3.5 rounded up to the
+// nearest 100ms, the coarsest granularity the termios VTIME field can
+// express. Client does not need this -- it enforces t3.5/t1.5 itself via
+// ReadContext/SetReadDeadline -- so only use it when reading the port
+// directly, without going through Client.
+func FrameTimeoutOptions(baudRate uint) (interCharacterTimeout, minimumReadSize uint) {
+	t35 := interFrameSilence(baudRate)
+
+	ms := uint(math.Ceil(float64(t35)/float64(time.Millisecond)/100) * 100)
+	if ms < 100 {
+		ms = 100
+	}
+
+	return ms, 255
+}
@@ -0,0 +1,46 @@
+package modbus
+
+import "testing"
+
+func TestInterFrameSilence(t *testing.T) {
+	cases := []struct {
+		baudRate uint
+		want     uint
+	}{
+		{0, 4010},      // defaults to 9600 baud
+		{9600, 4010},   // 3.5 * 11 / 9600 seconds, truncated to the microsecond
+		{115200, 1750}, // above 19200 baud, fixed at 1.75ms
+	}
+
+	for _, c := range cases {
+		if got := uint(interFrameSilence(c.baudRate).Microseconds()); got != c.want {
+			t.Errorf("interFrameSilence(%d) = %dus, want %dus", c.baudRate, got, c.want)
+		}
+	}
+}
+
+func TestIntraFrameGap(t *testing.T) {
+	cases := []struct {
+		baudRate uint
+		want     uint
+	}{
+		{0, 1718},     // defaults to 9600 baud
+		{9600, 1718},  // 1.5 * 11 / 9600 seconds, truncated to the microsecond
+		{115200, 750}, // above 19200 baud, fixed at 0.75ms
+	}
+
+	for _, c := range cases {
+		if got := uint(intraFrameGap(c.baudRate).Microseconds()); got != c.want {
+			t.Errorf("intraFrameGap(%d) = %dus, want %dus", c.baudRate, got, c.want)
+		}
+	}
+}
+
+func TestIntraFrameGapIsShorterThanInterFrameSilence(t *testing.T) {
+	for _, baudRate := range []uint{0, 1200, 9600, 19200, 38400, 115200} {
+		if intraFrameGap(baudRate) >= interFrameSilence(baudRate) {
+			t.Errorf("intraFrameGap(%d) = %s, want less than interFrameSilence = %s",
+				baudRate, intraFrameGap(baudRate), interFrameSilence(baudRate))
+		}
+	}
+}